@@ -0,0 +1,44 @@
+// Command opencx-migrate lists and applies cxdbsql's schema migrations
+// against a running MySQL instance, without going through the rest of
+// opencx's startup (SetupClient, auction rotation, and so on).
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/mit-dci/opencx/cxdb/cxdbsql"
+	"github.com/mit-dci/opencx/cxdb/cxdbsql/migrations"
+)
+
+func main() {
+	dsn := flag.String("dsn", "", "mysql data source name, e.g. user:password@tcp(host:port)/?parseTime=true")
+	pairs := flag.String("pairs", "", "comma-separated list of per-pair table names to migrate, as produced by the pairs' String method")
+	flag.Parse()
+
+	if *dsn == "" || *pairs == "" {
+		fmt.Fprintln(os.Stderr, "usage: opencx-migrate -dsn <dsn> -pairs <pair,pair,...> <list|up|up-to N>")
+		os.Exit(1)
+	}
+
+	db, err := sql.Open("mysql", *dsn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "opencx-migrate: could not open database: %s\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	pairStrings := strings.Split(*pairs, ",")
+	schemas := cxdbsql.MigrationSchemas(pairStrings)
+
+	if err = migrations.RunCLI(context.Background(), db, schemas, os.Stdout, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "opencx-migrate: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,362 @@
+package cxdbsql
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/opencx/cxdb"
+)
+
+// auctionsMetaSchemaSpec is the schema for auctionOrderTable, which tracks
+// every auction OpenAuction has rotated in: its ID, when it was opened, its
+// batch interval, and whether it's been closed.
+const auctionsMetaSchemaSpec = "id VARBINARY(32) PRIMARY KEY, openTime TIMESTAMP(6), batchIntervalSeconds BIGINT(64) UNSIGNED, closed BOOLEAN NOT NULL DEFAULT FALSE"
+
+// puzzleTableSchemaSpec is the schema for a single auction's puzzle table.
+// hashedOrder is the sealed bidder's commitment, checked by RevealOrder
+// against H(order||nonce) before the order is let into the auction
+// orderbook. revealed is set by RevealOrder itself so the same commitment
+// can't be revealed into the orderbook twice.
+const puzzleTableSchemaSpec = "encodedPuzzle VARBINARY(8192), hashedOrder VARBINARY(32), selected BOOLEAN NOT NULL DEFAULT FALSE, revealed BOOLEAN NOT NULL DEFAULT FALSE"
+
+// OpenAuction rotates in a new auction: it creates the pair puzzle table for
+// id and records the auction's open time and batch interval in
+// auctionOrderTable so SubmitPuzzle, RevealOrder, and CloseAuction can find it.
+func (db *DB) OpenAuction(id [32]byte, batchInterval time.Duration) (err error) {
+	idHex := hex.EncodeToString(id[:])
+
+	if err = db.InitializeSingleTable(db.puzzleSchema, idHex, puzzleTableSchemaSpec); err != nil {
+		err = fmt.Errorf("Could not initialize puzzle table for auction %s: \n%s", idHex, err)
+		return
+	}
+
+	if _, err = db.DBHandler.Exec("USE " + db.auctionOrderSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.auctionOrderSchema, err)
+		return
+	}
+
+	insertQuery := fmt.Sprintf("INSERT INTO %s (id, openTime, batchIntervalSeconds, closed) VALUES (?, NOW(6), ?, FALSE);", db.auctionOrderTable)
+	if _, err = db.DBHandler.Exec(insertQuery, id[:], int64(batchInterval.Seconds())); err != nil {
+		err = fmt.Errorf("Could not record auction %s in %s: \n%s", idHex, db.auctionOrderTable, err)
+		return
+	}
+
+	return
+}
+
+// SubmitPuzzle inserts a sealed bidder's encoded time-lock puzzle and its
+// hashedOrder commitment into auction id's puzzle table.
+func (db *DB) SubmitPuzzle(id [32]byte, encodedPuzzle []byte, hashedOrder [32]byte) (err error) {
+	idHex := hex.EncodeToString(id[:])
+
+	var closed bool
+	if closed, err = db.auctionClosed(id); err != nil {
+		return
+	}
+	if closed {
+		err = fmt.Errorf("Auction %s is closed, cannot submit puzzles", idHex)
+		return
+	}
+
+	if _, err = db.DBHandler.Exec("USE " + db.puzzleSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.puzzleSchema, err)
+		return
+	}
+
+	insertQuery := fmt.Sprintf("INSERT INTO %s (encodedPuzzle, hashedOrder, selected) VALUES (?, ?, FALSE);", idHex)
+	if _, err = db.DBHandler.Exec(insertQuery, encodedPuzzle, hashedOrder[:]); err != nil {
+		err = fmt.Errorf("Could not submit puzzle for auction %s: \n%s", idHex, err)
+		return
+	}
+
+	return
+}
+
+// SelectPuzzlesForBatch atomically selects every not-yet-selected puzzle
+// for auction id and marks it selected, under a row-level SELECT ... FOR
+// UPDATE, returning the hashedOrder commitments that were picked up by this
+// call. Revealers can only get their order into the orderbook (RevealOrder)
+// once their commitment shows up here.
+//
+// The USE and the transaction run over the same acquired connection: USE
+// only takes effect on whichever physical connection runs it, and a plain
+// db.Begin() can check out a different one from the pool, leaving the
+// SELECT ... FOR UPDATE with no schema selected.
+func (db *DB) SelectPuzzlesForBatch(id [32]byte) (hashedOrders [][]byte, err error) {
+	idHex := hex.EncodeToString(id[:])
+
+	ctx := context.Background()
+	var conn *sql.Conn
+	if conn, err = db.DBHandler.Conn(ctx); err != nil {
+		err = fmt.Errorf("Could not acquire a connection for auction %s: \n%s", idHex, err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "USE "+db.puzzleSchema+";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.puzzleSchema, err)
+		return
+	}
+
+	var tx *sql.Tx
+	if tx, err = conn.BeginTx(ctx, nil); err != nil {
+		err = fmt.Errorf("Could not begin transaction to select puzzles for auction %s: \n%s", idHex, err)
+		return
+	}
+
+	selectQuery := fmt.Sprintf("SELECT hashedOrder FROM %s WHERE selected = FALSE FOR UPDATE;", idHex)
+	var rows *sql.Rows
+	if rows, err = tx.Query(selectQuery); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Could not select puzzles for auction %s: \n%s", idHex, err)
+		return
+	}
+
+	for rows.Next() {
+		var hashedOrder []byte
+		if err = rows.Scan(&hashedOrder); err != nil {
+			rows.Close()
+			tx.Rollback()
+			err = fmt.Errorf("Could not scan puzzle for auction %s: \n%s", idHex, err)
+			return
+		}
+		hashedOrders = append(hashedOrders, hashedOrder)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		tx.Rollback()
+		err = fmt.Errorf("Error iterating puzzles for auction %s: \n%s", idHex, err)
+		return
+	}
+	rows.Close()
+
+	if len(hashedOrders) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(hashedOrders)), ",")
+		args := make([]interface{}, len(hashedOrders))
+		for i, hashedOrder := range hashedOrders {
+			args[i] = hashedOrder
+		}
+
+		// Scope the UPDATE to exactly the hashedOrders the SELECT above
+		// picked up, rather than a blanket "selected = FALSE" -- a puzzle
+		// submitted after the SELECT ran but before this UPDATE would
+		// otherwise also get marked selected despite never being returned
+		// in hashedOrders.
+		updateQuery := fmt.Sprintf("UPDATE %s SET selected = TRUE WHERE hashedOrder IN (%s);", idHex, placeholders)
+		if _, err = tx.Exec(updateQuery, args...); err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Could not mark puzzles selected for auction %s: \n%s", idHex, err)
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		err = fmt.Errorf("Could not commit puzzle selection for auction %s: \n%s", idHex, err)
+		return
+	}
+
+	return
+}
+
+// RevealOrder checks that order and nonce hash to a puzzle that was
+// committed (SubmitPuzzle) and selected for this batch (SelectPuzzlesForBatch)
+// under auction id, then inserts the revealed order into pairString's
+// auction order table.
+func (db *DB) RevealOrder(id [32]byte, pairString string, order cxdb.AuctionOrder, nonce [4]byte) (err error) {
+	idHex := hex.EncodeToString(id[:])
+
+	var closed bool
+	if closed, err = db.auctionClosed(id); err != nil {
+		return
+	}
+	if closed {
+		err = fmt.Errorf("Auction %s is closed, cannot reveal orders", idHex)
+		return
+	}
+
+	sum := sha256.Sum256(append(order.Bytes(), nonce[:]...))
+	hashedOrder := sum[:]
+
+	if _, err = db.DBHandler.Exec("USE " + db.puzzleSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.puzzleSchema, err)
+		return
+	}
+
+	// Atomically claim the puzzle: only a commitment that's selected and
+	// not yet revealed can flip to revealed, so a replayed (order, nonce)
+	// pair can't insert the same order into the orderbook twice.
+	claimQuery := fmt.Sprintf("UPDATE %s SET revealed = TRUE WHERE hashedOrder = ? AND selected = TRUE AND revealed = FALSE;", idHex)
+	var res sql.Result
+	if res, err = db.DBHandler.Exec(claimQuery, hashedOrder); err != nil {
+		err = fmt.Errorf("Could not claim puzzle commitment for auction %s: \n%s", idHex, err)
+		return
+	}
+	var claimed int64
+	if claimed, err = res.RowsAffected(); err != nil {
+		err = fmt.Errorf("Could not check claimed puzzle commitment for auction %s: \n%s", idHex, err)
+		return
+	}
+	if claimed == 0 {
+		err = fmt.Errorf("Revealed order does not match a selected, not-yet-revealed puzzle for auction %s", idHex)
+		return
+	}
+
+	if _, err = db.DBHandler.Exec("USE " + db.auctionSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.auctionSchema, err)
+		return
+	}
+
+	insertQuery := fmt.Sprintf("INSERT INTO %s (pubkey, orderID, side, price, amountHave, amountWant, auctionID, nonce, hashedOrder) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);", pairString)
+	if _, err = db.DBHandler.Exec(insertQuery, order.Pubkey, order.OrderID, order.Side, order.Price, order.AmountHave, order.AmountWant, id[:], nonce[:], hashedOrder); err != nil {
+		err = fmt.Errorf("Could not insert revealed order into %s: \n%s", pairString, err)
+		return
+	}
+
+	return
+}
+
+// CloseAuction freezes auction id against further puzzle submissions and
+// order reveals, runs match over its revealed orders, persists the
+// resulting fills to price history (see price.go), and archives the puzzle
+// table so a new auction can be opened in its place.
+func (db *DB) CloseAuction(id [32]byte, match func(id [32]byte) ([]cxdb.Fill, error)) (err error) {
+	idHex := hex.EncodeToString(id[:])
+
+	var alreadyClosed bool
+	if alreadyClosed, err = db.auctionClosed(id); err != nil {
+		return
+	}
+	if alreadyClosed {
+		err = fmt.Errorf("Auction %s is already closed", idHex)
+		return
+	}
+
+	if err = db.markAuctionClosed(id); err != nil {
+		return
+	}
+
+	var fills []cxdb.Fill
+	if fills, err = match(id); err != nil {
+		err = fmt.Errorf("Match callback failed for auction %s: \n%s", idHex, err)
+		return
+	}
+
+	now := time.Now()
+	for _, fill := range fills {
+		if err = db.RecordTrade(fill.Pair, fill.Price, fill.Volume, fill.Side, now); err != nil {
+			err = fmt.Errorf("Could not record fill for auction %s: \n%s", idHex, err)
+			return
+		}
+	}
+
+	if err = db.archivePuzzleTable(idHex); err != nil {
+		return
+	}
+
+	return
+}
+
+// SweepExpiredAuctions closes, matches, settles, and archives any auction
+// that's still open but was opened more than olderThan ago -- a maintenance
+// sweep for auctions that never got an explicit CloseAuction call, e.g. a
+// crashed batch. It runs match and records fills through CloseAuction, the
+// same as a normal close, so orders already revealed into an expired
+// auction still get settled instead of being stranded unmatched.
+func (db *DB) SweepExpiredAuctions(olderThan time.Duration, match func(id [32]byte) ([]cxdb.Fill, error)) (err error) {
+	if _, err = db.DBHandler.Exec("USE " + db.auctionOrderSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.auctionOrderSchema, err)
+		return
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	selectQuery := fmt.Sprintf("SELECT id FROM %s WHERE closed = FALSE AND openTime < ?;", db.auctionOrderTable)
+	var rows *sql.Rows
+	if rows, err = db.DBHandler.Query(selectQuery, cutoff); err != nil {
+		err = fmt.Errorf("Could not query expired auctions: \n%s", err)
+		return
+	}
+
+	var expiredIDs [][]byte
+	for rows.Next() {
+		var idBytes []byte
+		if err = rows.Scan(&idBytes); err != nil {
+			rows.Close()
+			err = fmt.Errorf("Could not scan expired auction id: \n%s", err)
+			return
+		}
+		expiredIDs = append(expiredIDs, idBytes)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		err = fmt.Errorf("Error iterating expired auctions: \n%s", err)
+		return
+	}
+	rows.Close()
+
+	for _, idBytes := range expiredIDs {
+		var id [32]byte
+		copy(id[:], idBytes)
+
+		if err = db.CloseAuction(id, match); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// auctionClosed reports whether auction id has already been closed.
+func (db *DB) auctionClosed(id [32]byte) (closed bool, err error) {
+	if _, err = db.DBHandler.Exec("USE " + db.auctionOrderSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.auctionOrderSchema, err)
+		return
+	}
+
+	row := db.DBHandler.QueryRow(fmt.Sprintf("SELECT closed FROM %s WHERE id = ?;", db.auctionOrderTable), id[:])
+	if err = row.Scan(&closed); err != nil {
+		err = fmt.Errorf("Could not check whether auction %s is closed: \n%s", hex.EncodeToString(id[:]), err)
+		return
+	}
+
+	return
+}
+
+// markAuctionClosed flips auctionOrderTable's closed flag for id, freezing
+// it against further SubmitPuzzle/RevealOrder calls.
+func (db *DB) markAuctionClosed(id [32]byte) (err error) {
+	if _, err = db.DBHandler.Exec("USE " + db.auctionOrderSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.auctionOrderSchema, err)
+		return
+	}
+
+	closeQuery := fmt.Sprintf("UPDATE %s SET closed = TRUE WHERE id = ?;", db.auctionOrderTable)
+	if _, err = db.DBHandler.Exec(closeQuery, id[:]); err != nil {
+		err = fmt.Errorf("Could not mark auction %s closed: \n%s", hex.EncodeToString(id[:]), err)
+		return
+	}
+
+	return
+}
+
+// archivePuzzleTable renames auction idHex's puzzle table out of the way so
+// a new auction can reuse the schema without the old commitments around.
+func (db *DB) archivePuzzleTable(idHex string) (err error) {
+	if _, err = db.DBHandler.Exec("USE " + db.puzzleSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.puzzleSchema, err)
+		return
+	}
+
+	archiveName := fmt.Sprintf("auction_%s_archive", idHex)
+	renameQuery := fmt.Sprintf("RENAME TABLE %s TO %s;", idHex, archiveName)
+	if _, err = db.DBHandler.Exec(renameQuery); err != nil {
+		err = fmt.Errorf("Could not archive puzzle table for auction %s: \n%s", idHex, err)
+		return
+	}
+
+	return
+}
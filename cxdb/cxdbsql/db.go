@@ -1,11 +1,12 @@
 package cxdbsql
 
 import (
+	"context"
 	"database/sql"
-	"encoding/hex"
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/mit-dci/lit/coinparam"
 
@@ -24,12 +25,22 @@ var (
 	orderSchema          = "orders"
 	peerSchema           = "peers"
 	peerTableName        = "opencxpeers"
+	priceSchema          = "prices"
+	puzzleSchema         = "puzzles"
+	auctionSchema        = "auctions"
+	auctionOrderSchema   = "auction_meta"
+	auctionOrderTable    = "auctions_meta"
+
+	// defaultBatchInterval is the batch interval SetupAuctionTables opens
+	// its initial auction with.
+	defaultBatchInterval = 10 * time.Second
 )
 
 // DB contains the sql DB type as well as a logger.
 // The database is a BEHEMOTH, should be refactored. Some examples on how to refactor are cleaning up mutexes, creating config file for all the globals,
 // What would be great is to move everything having to do with price and matching into match and making match more like a matching engine framework
-// or library for exchanges. This should conform to the cxdb interface, and if the server uses the noise protocol / authenticated networking, or anything
+// or library for exchanges. DB conforms to the cxdb.Store interface and is registered under the "mysql" driver name, so it can be opened with
+// cxdb.Open("mysql", ...) in place of CreateDBConnection/SetupClient. If the server uses the noise protocol / authenticated networking, or anything
 // that requires conforming to the lncore.LitPeerStorage interface, it should conform to that as well.
 type DB struct {
 	// the SQL handler for the db
@@ -50,6 +61,8 @@ type DB struct {
 	pendingDepositSchema string
 	// name of order schema
 	orderSchema string
+	// name of price history schema
+	priceSchema string
 
 	// peer schema stuff
 	// name of peer schema
@@ -73,7 +86,8 @@ type DB struct {
 	// the pairs that are supported. generated from coinList when the db is initiated
 	pairsArray []*match.Pair
 
-	// pricemap for pair that we manually add to
+	// gPriceMap is a hot cache of the last transacted price per pair, in
+	// front of the prices schema's per-pair history tables.
 	gPriceMap map[string]float64
 	// priceMapMtx is a lock for gPriceMap
 	priceMapMtx *sync.Mutex
@@ -86,12 +100,24 @@ func (db *DB) SetPrice(newPrice float64, pairString string) {
 	db.priceMapMtx.Unlock()
 }
 
-// GetPrice returns the price and side of the last transacted price
+// GetPrice returns the last transacted price for a pair. It reads the
+// gPriceMap cache first and falls back to the most recent row in that
+// pair's price history table -- for example right after a restart, before
+// any trade has repopulated the cache.
 func (db *DB) GetPrice(pairString string) (price float64, err error) {
-	var found bool
-	if price, found = db.gPriceMap[pairString]; !found {
-		err = fmt.Errorf("Could not get price, pair not found")
+	db.priceMapMtx.Lock()
+	cachedPrice, found := db.gPriceMap[pairString]
+	db.priceMapMtx.Unlock()
+	if found {
+		price = cachedPrice
+		return
+	}
+
+	if price, err = db.lastPriceFromHistory(pairString); err != nil {
+		return
 	}
+
+	db.SetPrice(price, pairString)
 	return
 }
 
@@ -126,6 +152,11 @@ func (db *DB) SetupClient(coinList []*coinparam.Params) (err error) {
 	db.orderSchema = orderSchema
 	db.peerSchema = peerSchema
 	db.peerTableName = peerTableName
+	db.priceSchema = priceSchema
+	db.puzzleSchema = puzzleSchema
+	db.auctionSchema = auctionSchema
+	db.auctionOrderSchema = auctionOrderSchema
+	db.auctionOrderTable = auctionOrderTable
 	// Create users and schemas and assign permissions to opencx
 	if err = db.rootInitSchemas(); err != nil {
 		err = fmt.Errorf("Root could not initialize schemas: \n%s", err)
@@ -176,6 +207,16 @@ func (db *DB) SetupClient(coinList []*coinparam.Params) (err error) {
 		return
 	}
 
+	if err = db.SetupPriceTables(db.priceSchema); err != nil {
+		err = fmt.Errorf("Error setting up price tables: %s", err)
+		return
+	}
+
+	if err = db.Migrate(context.Background()); err != nil {
+		err = fmt.Errorf("Error migrating schemas: %s", err)
+		return
+	}
+
 	return
 }
 
@@ -209,8 +250,11 @@ func (db *DB) SetupCustodyTables(balanceSchema string, depositSchema string, pen
 		return
 	}
 
-	// Initialize pending_deposits table
-	if err = db.InitializeNewTables(pendingDepositSchema, "pubkey VARBINARY(66), expectedConfirmHeight INT(32) UNSIGNED, depositHeight INT(32) UNSIGNED, amount BIGINT(64), txid TEXT"); err != nil {
+	// Initialize pending_deposits table. This used to go through
+	// InitializeNewTables, which CREATE OR REPLACEs the table on every
+	// startup and wipes it; schema evolution is now the migrations
+	// package's job, so a plain CREATE TABLE IF NOT EXISTS is enough here.
+	if err = db.InitializeTables(pendingDepositSchema, "pubkey VARBINARY(66), expectedConfirmHeight INT(32) UNSIGNED, depositHeight INT(32) UNSIGNED, amount BIGINT(64), txid TEXT"); err != nil {
 		err = fmt.Errorf("Could not initialize pending deposit tables: \n%s", err)
 		return
 	}
@@ -229,22 +273,32 @@ func (db *DB) SetupExchangeTables(orderSchema string) (err error) {
 	return
 }
 
-// SetupAuctionTables sets up the tables needed to store auction orders and puzzles for specific auctions
+// SetupAuctionTables sets up the schemas used for auctions -- the per-pair
+// auction order tables, and the auctions_meta table that OpenAuction rotates
+// auctions through -- and opens initialAuctionID as the first auction.
 func (db *DB) SetupAuctionTables(initialAuctionID [32]byte) (err error) {
-
 	// Initialize auction order schema, table
 	// An auction order is identified by it's auction ID, pubkey, nonce, and other specific data.
 	// You can have a price up to 30 digits total, and 10 decimal places.
-	if err = db.InitializePairTables(db.auctionSchema, "pubkey VARBINARY(66), orderID TEXT, side TEXT, price DOUBLE(30,2) UNSIGNED, amountHave BIGINT(64), amountWant BIGINT(64), auctionID VARBINARY(64), nonce VARBINARY(4), hashedOrder VARBINARY"); err != nil {
+	if err = db.InitializePairTables(db.auctionSchema, "pubkey VARBINARY(66), orderID TEXT, side TEXT, price DOUBLE(30,2) UNSIGNED, amountHave BIGINT(64), amountWant BIGINT(64), auctionID VARBINARY(64), nonce VARBINARY(4), hashedOrder VARBINARY(32)"); err != nil {
 		err = fmt.Errorf("Could not initialize order tables: \n%s", err)
 		return
 	}
 
-	// Yes yes I know fmt.Sprintf("%x", initialAuctionID) exists, this seems to get the point across quicker
-	initialAuctionTableName := hex.EncodeToString(initialAuctionID[:])
-	// the name of the table is the auction ID: We can just get auction puzzles by table. "selected" is whether or not it's been selected for the auction.
-	if err = db.InitializeSingleTable(db.puzzleSchema, initialAuctionTableName, "encodedPuzzle VARBINARY, selected BOOLEAN"); err != nil {
-		err = fmt.Errorf("Could not initialize puzzle tables: \n%s", err)
+	if err = db.InitializeSingleTable(db.auctionOrderSchema, db.auctionOrderTable, auctionsMetaSchemaSpec); err != nil {
+		err = fmt.Errorf("Could not initialize %s table: \n%s", db.auctionOrderTable, err)
+		return
+	}
+
+	if err = db.MigrateAuctionTables(context.Background()); err != nil {
+		err = fmt.Errorf("Error migrating auction tables: %s", err)
+		return
+	}
+
+	// OpenAuction creates the puzzle table named after the auction ID and
+	// records the auction in auctionOrderTable -- see auction.go.
+	if err = db.OpenAuction(initialAuctionID, defaultBatchInterval); err != nil {
+		err = fmt.Errorf("Could not open initial auction %x: \n%s", initialAuctionID, err)
 		return
 	}
 
@@ -286,6 +340,10 @@ func (db *DB) InitializeTables(schemaName string, schemaSpec string) (err error)
 }
 
 // InitializeNewTables initalizes tables based on schema and clears them.
+//
+// Deprecated: this CREATE OR REPLACEs the table, wiping any existing data,
+// on every call. Use InitializeTables for the initial create and the
+// migrations package to evolve the schema afterwards.
 func (db *DB) InitializeNewTables(schemaName string, schemaSpec string) (err error) {
 	// Use the schema
 	if _, err = db.DBHandler.Exec("USE " + schemaName + ";"); err != nil {
@@ -349,6 +407,10 @@ func (db *DB) rootInitSchemas() (err error) {
 		db.pendingDepositSchema,
 		db.orderSchema,
 		db.peerSchema,
+		db.priceSchema,
+		db.puzzleSchema,
+		db.auctionSchema,
+		db.auctionOrderSchema,
 	}
 
 	for _, schema := range schemasToCreate {
@@ -0,0 +1,79 @@
+package cxdbsql
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mit-dci/lit/coinparam"
+
+	"github.com/mit-dci/opencx/cxdb"
+)
+
+func init() {
+	cxdb.RegisterDriver("mysql", &mysqlDriver{})
+}
+
+// mysqlDriver is the cxdb.Driver that backs the "mysql" driver name.
+type mysqlDriver struct{}
+
+// Open expects a dataSourceName of the form "username:password@host:port",
+// connects to the database, and runs it through the same setup that
+// CreateDBConnection and SetupClient have always done.
+func (d *mysqlDriver) Open(dataSourceName string, coinList []*coinparam.Params) (store cxdb.Store, err error) {
+	var username, password, hostport string
+	if username, password, hostport, err = splitDataSourceName(dataSourceName); err != nil {
+		return
+	}
+
+	var host, portString string
+	if host, portString, err = net.SplitHostPort(hostport); err != nil {
+		err = fmt.Errorf("Could not parse host and port out of data source name: \n%s", err)
+		return
+	}
+
+	var port uint64
+	if port, err = strconv.ParseUint(portString, 10, 16); err != nil {
+		err = fmt.Errorf("Could not parse port out of data source name: \n%s", err)
+		return
+	}
+
+	var db *DB
+	if db, err = CreateDBConnection(username, password, host, uint16(port)); err != nil {
+		return
+	}
+
+	if err = db.SetupClient(coinList); err != nil {
+		return
+	}
+
+	store = db
+	return
+}
+
+// splitDataSourceName splits a "username:password@host:port" data source
+// name into its parts.
+func splitDataSourceName(dataSourceName string) (username string, password string, hostport string, err error) {
+	atIndex := strings.LastIndex(dataSourceName, "@")
+	if atIndex < 0 {
+		err = fmt.Errorf("Data source name %s missing '@' between credentials and host", dataSourceName)
+		return
+	}
+
+	creds := dataSourceName[:atIndex]
+	hostport = dataSourceName[atIndex+1:]
+
+	colonIndex := strings.Index(creds, ":")
+	if colonIndex < 0 {
+		err = fmt.Errorf("Data source name %s missing ':' between username and password", dataSourceName)
+		return
+	}
+
+	username = creds[:colonIndex]
+	password = creds[colonIndex+1:]
+	return
+}
+
+// ensure DB satisfies cxdb.Store
+var _ cxdb.Store = (*DB)(nil)
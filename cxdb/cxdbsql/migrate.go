@@ -0,0 +1,64 @@
+package cxdbsql
+
+import (
+	"context"
+
+	"github.com/mit-dci/opencx/cxdb/cxdbsql/migrations"
+)
+
+// OrderMigrations tracks schema changes to the per-pair order tables created
+// by SetupExchangeTables. Migration 1 is a no-op baseline that lets
+// schema_version start counting from the columns InitializePairTables
+// already creates; later migrations alter the table forward from there.
+var OrderMigrations = migrations.Registry{
+	{ID: 1, Description: "baseline", Up: "SELECT 1;"},
+	{ID: 2, Description: "add canceled flag to orders", Up: "ALTER TABLE {{table}} ADD COLUMN canceled BOOLEAN NOT NULL DEFAULT FALSE;"},
+}
+
+// AuctionOrderMigrations tracks schema changes to the per-pair auction order
+// tables created by SetupAuctionTables.
+var AuctionOrderMigrations = migrations.Registry{
+	{ID: 1, Description: "baseline", Up: "SELECT 1;"},
+	{ID: 2, Description: "add settled flag to auction orders", Up: "ALTER TABLE {{table}} ADD COLUMN settled BOOLEAN NOT NULL DEFAULT FALSE;"},
+}
+
+// Migrate brings the per-pair order tables up to date; the auction order
+// tables are migrated by MigrateAuctionTables instead. It must run after the
+// baseline tables have been created by SetupCustodyTables/SetupExchangeTables,
+// since migrations alter existing tables rather than create them.
+func (db *DB) Migrate(ctx context.Context) (err error) {
+	orderTableNames := make([]string, len(db.pairsArray))
+	for i, pair := range db.pairsArray {
+		orderTableNames[i] = pair.String()
+	}
+
+	if err = migrations.MigrateTables(ctx, db.DBHandler, db.orderSchema, orderTableNames, OrderMigrations); err != nil {
+		return
+	}
+
+	return
+}
+
+// MigrateAuctionTables brings the auction order schema up to date for every
+// supported pair's auction order table. It's separate from Migrate because auction
+// tables, unlike order tables, aren't set up as part of SetupClient --
+// SetupAuctionTables calls it directly instead.
+func (db *DB) MigrateAuctionTables(ctx context.Context) (err error) {
+	auctionOrderTableNames := make([]string, len(db.pairsArray))
+	for i, pair := range db.pairsArray {
+		auctionOrderTableNames[i] = pair.String()
+	}
+
+	return migrations.MigrateTables(ctx, db.DBHandler, db.auctionSchema, auctionOrderTableNames, AuctionOrderMigrations)
+}
+
+// MigrationSchemas returns the migrations.Schema list operators can pass to
+// migrations.RunCLI to list and apply every schema migration cxdbsql knows
+// about -- the order tables and the auction order tables -- for the given
+// per-pair table names.
+func MigrationSchemas(pairStrings []string) []migrations.Schema {
+	return []migrations.Schema{
+		{Name: orderSchema, TableNames: pairStrings, Registry: OrderMigrations},
+		{Name: auctionSchema, TableNames: pairStrings, Registry: AuctionOrderMigrations},
+	}
+}
@@ -0,0 +1,132 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Status reports whether a single (migration, table) pair has been applied.
+type Status struct {
+	Migration
+	TableName string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// List reports the status of every migration in reg against every table in
+// tableNames, for the "list" CLI subcommand.
+func List(ctx context.Context, db *sql.DB, schemaName string, tableNames []string, reg Registry) (statuses []Status, err error) {
+	// USE and the queries after it share one connection, as in MigrateTables.
+	var conn *sql.Conn
+	if conn, err = db.Conn(ctx); err != nil {
+		err = fmt.Errorf("Could not acquire a connection to list %s: \n%s", schemaName, err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "USE "+schemaName+";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", schemaName, err)
+		return
+	}
+
+	for _, tableName := range tableNames {
+		for _, m := range reg {
+			st := Status{Migration: m, TableName: tableName}
+
+			row := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT applied_at FROM %s WHERE id = ? AND table_name = ?;", schemaVersionTable), m.ID, tableName)
+			switch scanErr := row.Scan(&st.AppliedAt); scanErr {
+			case nil:
+				st.Applied = true
+			case sql.ErrNoRows:
+				// not applied
+			default:
+				err = fmt.Errorf("Could not check %s for migration %d on table %s: \n%s", schemaVersionTable, m.ID, tableName, scanErr)
+				return
+			}
+
+			statuses = append(statuses, st)
+		}
+	}
+
+	return
+}
+
+// Up applies every pending migration in reg, for the "up" CLI subcommand.
+func Up(ctx context.Context, db *sql.DB, schemaName string, tableNames []string, reg Registry) (err error) {
+	return MigrateTables(ctx, db, schemaName, tableNames, reg)
+}
+
+// UpTo applies every pending migration in reg up to and including id, for
+// the "up-to" CLI subcommand.
+func UpTo(ctx context.Context, db *sql.DB, schemaName string, tableNames []string, reg Registry, id int) (err error) {
+	var capped Registry
+	for _, m := range reg {
+		if m.ID <= id {
+			capped = append(capped, m)
+		}
+	}
+
+	return MigrateTables(ctx, db, schemaName, tableNames, capped)
+}
+
+// Schema bundles everything RunCLI needs to operate on one managed schema.
+type Schema struct {
+	Name       string
+	TableNames []string
+	Registry   Registry
+}
+
+// RunCLI implements the "list", "up", and "up-to N" migration subcommands an
+// operator-facing CLI can wire up, operating on every schema passed in.
+func RunCLI(ctx context.Context, db *sql.DB, schemas []Schema, out io.Writer, args []string) (err error) {
+	if len(args) == 0 {
+		err = fmt.Errorf("usage: migrate <list|up|up-to N>")
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		for _, s := range schemas {
+			var statuses []Status
+			if statuses, err = List(ctx, db, s.Name, s.TableNames, s.Registry); err != nil {
+				return
+			}
+			for _, st := range statuses {
+				fmt.Fprintf(out, "%s\t%s\t%d\t%s\tapplied=%t\n", s.Name, st.TableName, st.ID, st.Description, st.Applied)
+			}
+		}
+	case "up":
+		for _, s := range schemas {
+			if err = Up(ctx, db, s.Name, s.TableNames, s.Registry); err != nil {
+				return
+			}
+		}
+	case "up-to":
+		fs := flag.NewFlagSet("up-to", flag.ContinueOnError)
+		if err = fs.Parse(args[1:]); err != nil {
+			return
+		}
+		if fs.NArg() != 1 {
+			err = fmt.Errorf("usage: migrate up-to N")
+			return
+		}
+		var id int
+		if _, err = fmt.Sscanf(fs.Arg(0), "%d", &id); err != nil {
+			err = fmt.Errorf("Could not parse migration id %q: \n%s", fs.Arg(0), err)
+			return
+		}
+		for _, s := range schemas {
+			if err = UpTo(ctx, db, s.Name, s.TableNames, s.Registry, id); err != nil {
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown migrate subcommand %q", args[0])
+	}
+
+	return
+}
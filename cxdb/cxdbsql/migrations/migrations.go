@@ -0,0 +1,151 @@
+// Package migrations implements numbered, checksummed SQL migrations for
+// cxdbsql schemas, tracked in a per-schema schema_version table. It exists
+// so that a column added to an already-deployed table (for example
+// hashedOrder on auction orders, or a new flag on orders) can be rolled out
+// without the CREATE TABLE IF NOT EXISTS calls in cxdbsql silently no-oping
+// on existing installs, and without resorting to a CREATE OR REPLACE that
+// would wipe the table's data.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// tablePlaceholder is substituted with the real table name in a Migration's
+// Up SQL before it runs, so one Migration can be applied across every
+// per-coin or per-pair table in a schema.
+const tablePlaceholder = "{{table}}"
+
+// schemaVersionTable is the name of the table each migrated schema gets to
+// track which migrations have been applied to which of its tables.
+const schemaVersionTable = "schema_version"
+
+// Migration is a single numbered schema change. Up may reference
+// tablePlaceholder ("{{table}}") when it needs to run against one table at
+// a time within a schema that has one table per coin or pair.
+type Migration struct {
+	ID          int
+	Description string
+	Up          string
+}
+
+// Registry is an ordered, numbered list of migrations for a single schema.
+// IDs must be unique and are expected, but not required, to be sequential.
+type Registry []Migration
+
+// render returns m.Up with tablePlaceholder replaced by tableName.
+func (m Migration) render(tableName string) string {
+	return strings.ReplaceAll(m.Up, tablePlaceholder, tableName)
+}
+
+// checksum returns the sha256 checksum of m's rendered Up SQL, used to
+// detect a previously-applied migration being modified after the fact.
+func (m Migration) checksum(tableName string) string {
+	sum := sha256.Sum256([]byte(m.render(tableName)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Migrate applies reg to the single table tableName within schemaName.
+func Migrate(ctx context.Context, db *sql.DB, schemaName string, tableName string, reg Registry) (err error) {
+	return MigrateTables(ctx, db, schemaName, []string{tableName}, reg)
+}
+
+// MigrateTables brings every table in tableNames up to date by applying any
+// migrations in reg that haven't yet been recorded against that table in
+// schemaName's schema_version table. Each (migration, table) pair is applied
+// in its own transaction, so a failure partway through leaves already-applied
+// tables migrated and stops before the rest.
+//
+// Everything here runs over a single acquired connection rather than the
+// pooled *sql.DB directly: the USE below only selects a default schema on
+// whichever physical connection runs it, so issuing it on db and later
+// statements on connections db.QueryRowContext/db.BeginTx happen to check
+// out from the pool would silently run unqualified, schema-less.
+func MigrateTables(ctx context.Context, db *sql.DB, schemaName string, tableNames []string, reg Registry) (err error) {
+	var conn *sql.Conn
+	if conn, err = db.Conn(ctx); err != nil {
+		err = fmt.Errorf("Could not acquire a connection to migrate %s: \n%s", schemaName, err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "USE "+schemaName+";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", schemaName, err)
+		return
+	}
+
+	createVersionTable := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (id INT(32) UNSIGNED, table_name VARCHAR(128), description TEXT, checksum CHAR(64), applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (id, table_name));",
+		schemaVersionTable,
+	)
+	if _, err = conn.ExecContext(ctx, createVersionTable); err != nil {
+		err = fmt.Errorf("Could not create %s table: \n%s", schemaVersionTable, err)
+		return
+	}
+
+	for _, tableName := range tableNames {
+		for _, m := range reg {
+			if err = applyMigration(ctx, conn, m, tableName); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// applyMigration applies a single migration to a single table if it hasn't
+// already been recorded, and errors out if a previously-applied migration's
+// rendered SQL no longer matches its recorded checksum -- this is the
+// "modified past migration" detection. conn must already have schemaName
+// selected via USE, from MigrateTables.
+func applyMigration(ctx context.Context, conn *sql.Conn, m Migration, tableName string) (err error) {
+	var recordedChecksum string
+	row := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT checksum FROM %s WHERE id = ? AND table_name = ?;", schemaVersionTable), m.ID, tableName)
+	switch scanErr := row.Scan(&recordedChecksum); scanErr {
+	case nil:
+		if recordedChecksum != m.checksum(tableName) {
+			err = fmt.Errorf("migration %d (%s) has been modified since it was applied to table %s", m.ID, m.Description, tableName)
+		}
+		return
+	case sql.ErrNoRows:
+		// not yet applied to this table, fall through and apply it
+	default:
+		err = fmt.Errorf("Could not check %s for migration %d on table %s: \n%s", schemaVersionTable, m.ID, tableName, scanErr)
+		return
+	}
+
+	// Most DDL statements implicitly commit in MySQL, so this transaction
+	// can't roll back the Up statement itself, but it does keep the
+	// bookkeeping insert atomic with statements that can be rolled back.
+	var tx *sql.Tx
+	if tx, err = conn.BeginTx(ctx, nil); err != nil {
+		err = fmt.Errorf("Could not begin transaction for migration %d on table %s: \n%s", m.ID, tableName, err)
+		return
+	}
+
+	if _, err = tx.ExecContext(ctx, m.render(tableName)); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Could not apply migration %d (%s) to table %s: \n%s", m.ID, m.Description, tableName, err)
+		return
+	}
+
+	insertVersion := fmt.Sprintf("INSERT INTO %s (id, table_name, description, checksum) VALUES (?, ?, ?, ?);", schemaVersionTable)
+	if _, err = tx.ExecContext(ctx, insertVersion, m.ID, tableName, m.Description, m.checksum(tableName)); err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("Could not record migration %d (%s) for table %s: \n%s", m.ID, m.Description, tableName, err)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		err = fmt.Errorf("Could not commit migration %d (%s) for table %s: \n%s", m.ID, m.Description, tableName, err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,123 @@
+package cxdbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/opencx/cxdb"
+)
+
+// resolutionSeconds maps a supported candle resolution to its bucket size.
+var resolutionSeconds = map[string]int64{
+	"1m": 60,
+	"5m": 5 * 60,
+	"1h": 60 * 60,
+	"1d": 24 * 60 * 60,
+}
+
+// SetupPriceTables sets up the tables needed to store trade price history, one per pair.
+func (db *DB) SetupPriceTables(priceSchema string) (err error) {
+	if err = db.InitializePairTables(db.priceSchema, "ts TIMESTAMP(6), price DOUBLE, volume BIGINT, side ENUM('buy','sell')"); err != nil {
+		err = fmt.Errorf("Could not initialize price tables: \n%s", err)
+		return
+	}
+	return
+}
+
+// RecordTrade appends a single trade to pairString's price history and
+// refreshes the gPriceMap cache that GetPrice reads from. The matching
+// engine's fill callbacks should call this for every fill so that price
+// history and last-price are always persisted automatically.
+//
+// NOTE: this repository snapshot has no matching-engine package for that
+// wiring to live in -- the only caller in this tree is CloseAuction (see
+// auction.go), and only for auction fills. A continuous/limit matching path
+// calling RecordTrade for every fill is real remaining work that belongs in
+// that package once it's part of the tree.
+func (db *DB) RecordTrade(pairString string, price float64, volume int64, side string, ts time.Time) (err error) {
+	if _, err = db.DBHandler.Exec("USE " + db.priceSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.priceSchema, err)
+		return
+	}
+
+	insertQuery := fmt.Sprintf("INSERT INTO %s (ts, price, volume, side) VALUES (?, ?, ?, ?);", pairString)
+	if _, err = db.DBHandler.Exec(insertQuery, ts, price, volume, side); err != nil {
+		err = fmt.Errorf("Could not record trade for %s: \n%s", pairString, err)
+		return
+	}
+
+	db.SetPrice(price, pairString)
+	return
+}
+
+// GetPriceHistory returns the OHLCV candles for pairString between from and
+// to, bucketed by resolution ("1m", "5m", "1h", or "1d").
+func (db *DB) GetPriceHistory(pairString string, from time.Time, to time.Time, resolution string) (candles []cxdb.Candle, err error) {
+	bucketSeconds, ok := resolutionSeconds[resolution]
+	if !ok {
+		err = fmt.Errorf("Unknown resolution %s", resolution)
+		return
+	}
+
+	if _, err = db.DBHandler.Exec("USE " + db.priceSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.priceSchema, err)
+		return
+	}
+
+	query := fmt.Sprintf(`SELECT
+			CAST(FLOOR(UNIX_TIMESTAMP(ts) / ?) * ? AS SIGNED) AS bucket,
+			SUBSTRING_INDEX(GROUP_CONCAT(price ORDER BY ts ASC), ',', 1) AS open,
+			MAX(price) AS high,
+			MIN(price) AS low,
+			SUBSTRING_INDEX(GROUP_CONCAT(price ORDER BY ts DESC), ',', 1) AS close,
+			SUM(volume) AS volume
+		FROM %s
+		WHERE ts BETWEEN ? AND ?
+		GROUP BY bucket
+		ORDER BY bucket ASC;`, pairString)
+
+	var rows *sql.Rows
+	if rows, err = db.DBHandler.Query(query, bucketSeconds, bucketSeconds, from, to); err != nil {
+		err = fmt.Errorf("Could not get price history for %s: \n%s", pairString, err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c cxdb.Candle
+		var bucketStart int64
+		if err = rows.Scan(&bucketStart, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume); err != nil {
+			err = fmt.Errorf("Could not scan price history row for %s: \n%s", pairString, err)
+			return
+		}
+		c.Time = time.Unix(bucketStart, 0).UTC()
+		candles = append(candles, c)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error iterating price history rows for %s: \n%s", pairString, err)
+	}
+	return
+}
+
+// lastPriceFromHistory reads the most recent row out of pairString's price
+// history table, for GetPrice's cache-miss fallback.
+func (db *DB) lastPriceFromHistory(pairString string) (price float64, err error) {
+	if _, err = db.DBHandler.Exec("USE " + db.priceSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: \n%s", db.priceSchema, err)
+		return
+	}
+
+	query := fmt.Sprintf("SELECT price FROM %s ORDER BY ts DESC LIMIT 1;", pairString)
+	row := db.DBHandler.QueryRow(query)
+	if err = row.Scan(&price); err != nil {
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("Could not get price, pair not found")
+		} else {
+			err = fmt.Errorf("Could not query last price for %s: \n%s", pairString, err)
+		}
+		return
+	}
+
+	return
+}
@@ -0,0 +1,110 @@
+package cxdb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// Store is the interface that a storage backend for the exchange must
+// implement. It covers price reporting, custody, order, auction/puzzle,
+// and peer storage. Anything that conforms to Store can be handed to the
+// server, RPC layer, and matching engine in place of a concrete DB type.
+//
+// NOTE: this repository snapshot contains only the cxdb packages and the
+// opencx-migrate command -- there's no server, RPC, or matching-engine
+// package here to actually migrate off *cxdbsql.DB onto Store. That's real
+// remaining work, not something this change can do without those packages
+// present to edit.
+type Store interface {
+	// SetPrice sets the last-traded price for a pair.
+	SetPrice(newPrice float64, pairString string)
+	// GetPrice returns the last-traded price for a pair.
+	GetPrice(pairString string) (price float64, err error)
+	// GetPairs returns the list of supported pairs.
+	GetPairs() (pairArray []*match.Pair)
+
+	// RecordTrade appends a single trade to pairString's price history and
+	// refreshes the last-price cache GetPrice reads from.
+	RecordTrade(pairString string, price float64, volume int64, side string, ts time.Time) (err error)
+	// GetPriceHistory returns the OHLCV candles for pairString between from
+	// and to, bucketed by resolution ("1m", "5m", "1h", or "1d").
+	GetPriceHistory(pairString string, from time.Time, to time.Time, resolution string) (candles []Candle, err error)
+
+	// SetupCustodyTables sets up the tables needed to track user funds.
+	SetupCustodyTables(balanceSchema string, depositSchema string, pendingDepositSchema string) (err error)
+	// SetupExchangeTables sets up the tables needed for an orderbook.
+	SetupExchangeTables(orderSchema string) (err error)
+	// SetupAuctionTables sets up the tables needed to store auction orders and puzzles.
+	SetupAuctionTables(initialAuctionID [32]byte) (err error)
+	// SetupPeerTables sets up tables required to conform to Lit Peer Storage.
+	SetupPeerTables(peerSchema string, peerTable string) (err error)
+
+	// OpenAuction rotates in a new auction, ready to take puzzle commitments.
+	OpenAuction(id [32]byte, batchInterval time.Duration) (err error)
+	// SubmitPuzzle inserts a sealed bidder's encoded puzzle and hashedOrder
+	// commitment into auction id's puzzle table.
+	SubmitPuzzle(id [32]byte, encodedPuzzle []byte, hashedOrder [32]byte) (err error)
+	// SelectPuzzlesForBatch atomically selects every not-yet-selected
+	// puzzle for auction id and marks it selected, returning the
+	// hashedOrder commitments picked up by this call.
+	SelectPuzzlesForBatch(id [32]byte) (hashedOrders [][]byte, err error)
+	// RevealOrder checks that order and nonce hash to a selected puzzle
+	// commitment under auction id, then inserts the order into pairString's
+	// auction order table.
+	RevealOrder(id [32]byte, pairString string, order AuctionOrder, nonce [4]byte) (err error)
+	// CloseAuction freezes auction id, runs match over its revealed
+	// orders, records the resulting fills to price history, and archives
+	// its puzzle table.
+	CloseAuction(id [32]byte, match func(id [32]byte) ([]Fill, error)) (err error)
+	// SweepExpiredAuctions closes, matches, and archives any auction still
+	// open but opened more than olderThan ago.
+	SweepExpiredAuctions(olderThan time.Duration, match func(id [32]byte) ([]Fill, error)) (err error)
+}
+
+// Driver is implemented by a storage backend package so it can be looked up
+// by name from Open, the way database/sql drivers register themselves.
+type Driver interface {
+	// Open parses dataSourceName, connects to (or allocates, for an
+	// in-memory backend) the underlying store, and returns it ready to
+	// have its Setup* tables created.
+	Open(dataSourceName string, coinList []*coinparam.Params) (store Store, err error)
+}
+
+var (
+	driversMtx sync.Mutex
+	drivers    = make(map[string]Driver)
+)
+
+// RegisterDriver makes a Driver available by name. It is meant to be called
+// from a backend package's init function, analogous to database/sql.Register.
+func RegisterDriver(name string, driver Driver) {
+	driversMtx.Lock()
+	defer driversMtx.Unlock()
+	if driver == nil {
+		panic("cxdb: RegisterDriver called with nil driver")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("cxdb: RegisterDriver called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Open opens a Store using the driver registered under driverName. The
+// format of dataSourceName is driver-specific; drivers that don't need one
+// (e.g. an in-memory driver) may ignore it.
+func Open(driverName string, dataSourceName string, coinList []*coinparam.Params) (store Store, err error) {
+	driversMtx.Lock()
+	driver, ok := drivers[driverName]
+	driversMtx.Unlock()
+	if !ok {
+		err = fmt.Errorf("cxdb: unknown driver %q (forgotten import?)", driverName)
+		return
+	}
+
+	return driver.Open(dataSourceName, coinList)
+}
@@ -0,0 +1,465 @@
+// Package memdb is a pure Go, in-memory cxdb.Store. It registers itself
+// under the "memdb" driver name so tests can get a full ephemeral store via
+// cxdb.Open("memdb", ...) without spinning up a MySQL server.
+package memdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// defaultBatchInterval is how often an auction batches orders if the caller
+// of SetupAuctionTables doesn't open a later auction with a different
+// interval -- matches cxdbsql's default.
+const defaultBatchInterval = 10 * time.Second
+
+func init() {
+	cxdb.RegisterDriver("memdb", &memDriver{})
+}
+
+// resolutionSeconds maps a supported candle resolution to its bucket size,
+// matching cxdbsql's GetPriceHistory.
+var resolutionSeconds = map[string]int64{
+	"1m": 60,
+	"5m": 5 * 60,
+	"1h": 60 * 60,
+	"1d": 24 * 60 * 60,
+}
+
+// memDriver is the cxdb.Driver that backs the "memdb" driver name.
+type memDriver struct{}
+
+// Open ignores dataSourceName -- there's nothing to connect to -- and hands
+// back a ready-to-use, empty MemDB.
+func (d *memDriver) Open(dataSourceName string, coinList []*coinparam.Params) (store cxdb.Store, err error) {
+	db := &MemDB{
+		gPriceMap:            make(map[string]float64),
+		priceMapMtx:          new(sync.Mutex),
+		priceHistory:         make(map[string][]memTrade),
+		coinList:             coinList,
+		balanceTables:        make(map[string]bool),
+		depositTables:        make(map[string]bool),
+		pendingDepositTables: make(map[string]bool),
+		orderTables:          make(map[string]bool),
+		auctionOrderTables:   make(map[string]bool),
+		puzzleTables:         make(map[string]bool),
+		auctionsMeta:         make(map[string]*memAuctionMeta),
+		puzzles:              make(map[string][]*memPuzzle),
+		revealedOrders:       make(map[string][]memRevealedOrder),
+	}
+
+	if db.pairsArray, err = match.GenerateAssetPairs(coinList); err != nil {
+		return
+	}
+
+	store = db
+	return
+}
+
+// MemDB is an in-memory cxdb.Store. It mirrors the per-coin and per-pair
+// tables that cxdbsql.DB creates in MySQL with plain maps, guarded by mtx
+// (gPriceMap and priceHistory are guarded by priceMapMtx instead), so that
+// matching/custody logic can be exercised in tests without a live database.
+type MemDB struct {
+	mtx sync.Mutex
+
+	coinList   []*coinparam.Params
+	pairsArray []*match.Pair
+
+	// pricemap for pair that we manually add to
+	gPriceMap map[string]float64
+	// priceMapMtx is a lock for gPriceMap
+	priceMapMtx *sync.Mutex
+	// priceHistory holds every trade RecordTrade has appended, per pair, in
+	// the order they were recorded.
+	priceHistory map[string][]memTrade
+
+	// balanceTables, depositTables, and pendingDepositTables hold one
+	// entry per coin, mirroring the per-coin tables SetupCustodyTables
+	// creates in MySQL.
+	balanceTables        map[string]bool
+	depositTables        map[string]bool
+	pendingDepositTables map[string]bool
+
+	// orderTables and auctionOrderTables hold one entry per pair.
+	orderTables        map[string]bool
+	auctionOrderTables map[string]bool
+
+	// puzzleTables holds one entry per auction ID, hex-encoded.
+	puzzleTables map[string]bool
+
+	// auctionsMeta, puzzles, and revealedOrders back the auction lifecycle
+	// (OpenAuction through SweepExpiredAuctions), keyed the same way
+	// cxdbsql keys its auctions_meta and per-auction puzzle tables: by
+	// hex-encoded auction ID, except revealedOrders which -- like the
+	// per-pair auction order tables -- is keyed by pair string.
+	auctionsMeta   map[string]*memAuctionMeta
+	puzzles        map[string][]*memPuzzle
+	revealedOrders map[string][]memRevealedOrder
+
+	// peerTableSet is true once SetupPeerTables has been called.
+	peerTableSet bool
+}
+
+// ensure MemDB satisfies cxdb.Store
+var _ cxdb.Store = (*MemDB)(nil)
+
+// SetPrice sets the price, uses a lock since it will be written to and read from possibly at the same time.
+func (db *MemDB) SetPrice(newPrice float64, pairString string) {
+	db.priceMapMtx.Lock()
+	db.gPriceMap[pairString] = newPrice
+	db.priceMapMtx.Unlock()
+}
+
+// GetPrice returns the last transacted price for a pair
+func (db *MemDB) GetPrice(pairString string) (price float64, err error) {
+	db.priceMapMtx.Lock()
+	defer db.priceMapMtx.Unlock()
+	var found bool
+	if price, found = db.gPriceMap[pairString]; !found {
+		err = fmt.Errorf("Could not get price, pair not found")
+	}
+	return
+}
+
+// GetPairs returns the pairs list
+func (db *MemDB) GetPairs() (pairArray []*match.Pair) {
+	pairArray = db.pairsArray
+	return
+}
+
+// SetupCustodyTables sets up the tables needed to track what funds a user has
+func (db *MemDB) SetupCustodyTables(balanceSchema string, depositSchema string, pendingDepositSchema string) (err error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	for _, chain := range db.coinList {
+		db.balanceTables[chain.Name] = true
+		db.depositTables[chain.Name] = true
+		db.pendingDepositTables[chain.Name] = true
+	}
+
+	return
+}
+
+// SetupExchangeTables sets up the tables needed for an orderbook
+func (db *MemDB) SetupExchangeTables(orderSchema string) (err error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	for _, pair := range db.pairsArray {
+		db.orderTables[pair.String()] = true
+	}
+
+	return
+}
+
+// SetupAuctionTables sets up the tables needed to store auction orders and
+// puzzles, and opens initialAuctionID as the first auction, mirroring
+// cxdbsql.DB.SetupAuctionTables.
+func (db *MemDB) SetupAuctionTables(initialAuctionID [32]byte) (err error) {
+	db.mtx.Lock()
+	for _, pair := range db.pairsArray {
+		db.auctionOrderTables[pair.String()] = true
+	}
+	db.mtx.Unlock()
+
+	if err = db.OpenAuction(initialAuctionID, defaultBatchInterval); err != nil {
+		err = fmt.Errorf("Could not open initial auction %x: \n%s", initialAuctionID, err)
+		return
+	}
+
+	return
+}
+
+// SetupPeerTables sets up tables required for the database to conform to Lit Peer Storage
+func (db *MemDB) SetupPeerTables(peerSchema string, peerTable string) (err error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	db.peerTableSet = true
+	return
+}
+
+// memTrade is a single trade recorded by RecordTrade, kept in priceHistory.
+type memTrade struct {
+	ts     time.Time
+	price  float64
+	volume int64
+	side   string
+}
+
+// RecordTrade appends a single trade to pairString's price history and
+// refreshes the price cache GetPrice reads from.
+func (db *MemDB) RecordTrade(pairString string, price float64, volume int64, side string, ts time.Time) (err error) {
+	db.priceMapMtx.Lock()
+	db.priceHistory[pairString] = append(db.priceHistory[pairString], memTrade{ts: ts, price: price, volume: volume, side: side})
+	db.priceMapMtx.Unlock()
+
+	db.SetPrice(price, pairString)
+	return
+}
+
+// GetPriceHistory returns the OHLCV candles for pairString between from and
+// to, bucketed by resolution ("1m", "5m", "1h", or "1d").
+func (db *MemDB) GetPriceHistory(pairString string, from time.Time, to time.Time, resolution string) (candles []cxdb.Candle, err error) {
+	bucketSeconds, ok := resolutionSeconds[resolution]
+	if !ok {
+		err = fmt.Errorf("Unknown resolution %s", resolution)
+		return
+	}
+
+	db.priceMapMtx.Lock()
+	trades := make([]memTrade, len(db.priceHistory[pairString]))
+	copy(trades, db.priceHistory[pairString])
+	db.priceMapMtx.Unlock()
+
+	sort.Slice(trades, func(i, j int) bool { return trades[i].ts.Before(trades[j].ts) })
+
+	buckets := make(map[int64]*cxdb.Candle)
+	var bucketOrder []int64
+	for _, trade := range trades {
+		if trade.ts.Before(from) || trade.ts.After(to) {
+			continue
+		}
+
+		bucketStart := trade.ts.Unix() / bucketSeconds * bucketSeconds
+		c, ok := buckets[bucketStart]
+		if !ok {
+			c = &cxdb.Candle{
+				Time: time.Unix(bucketStart, 0).UTC(),
+				Open: trade.price,
+				High: trade.price,
+				Low:  trade.price,
+			}
+			buckets[bucketStart] = c
+			bucketOrder = append(bucketOrder, bucketStart)
+		}
+
+		if trade.price > c.High {
+			c.High = trade.price
+		}
+		if trade.price < c.Low {
+			c.Low = trade.price
+		}
+		c.Close = trade.price
+		c.Volume += trade.volume
+	}
+
+	sort.Slice(bucketOrder, func(i, j int) bool { return bucketOrder[i] < bucketOrder[j] })
+	for _, bucketStart := range bucketOrder {
+		candles = append(candles, *buckets[bucketStart])
+	}
+
+	return
+}
+
+// memAuctionMeta tracks an auction's open time, batch interval, and closed
+// flag, mirroring a row in cxdbsql's auctions_meta table.
+type memAuctionMeta struct {
+	openTime      time.Time
+	batchInterval time.Duration
+	closed        bool
+}
+
+// memPuzzle is a single sealed bidder's commitment, mirroring a row in one
+// of cxdbsql's per-auction puzzle tables. revealed is set by RevealOrder so
+// the same commitment can't be revealed into the orderbook twice.
+type memPuzzle struct {
+	encodedPuzzle []byte
+	hashedOrder   []byte
+	selected      bool
+	revealed      bool
+}
+
+// memRevealedOrder is a single order revealed into an auction's orderbook,
+// mirroring a row in one of cxdbsql's per-pair auction order tables.
+type memRevealedOrder struct {
+	auctionID [32]byte
+	order     cxdb.AuctionOrder
+}
+
+// OpenAuction rotates in a new auction, ready to take puzzle commitments.
+func (db *MemDB) OpenAuction(id [32]byte, batchInterval time.Duration) (err error) {
+	idHex := hex.EncodeToString(id[:])
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	db.puzzleTables[idHex] = true
+	db.auctionsMeta[idHex] = &memAuctionMeta{openTime: time.Now(), batchInterval: batchInterval}
+	db.puzzles[idHex] = nil
+
+	return
+}
+
+// SubmitPuzzle inserts a sealed bidder's encoded puzzle and hashedOrder
+// commitment into auction id's puzzle table.
+func (db *MemDB) SubmitPuzzle(id [32]byte, encodedPuzzle []byte, hashedOrder [32]byte) (err error) {
+	idHex := hex.EncodeToString(id[:])
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	meta, ok := db.auctionsMeta[idHex]
+	if !ok || meta.closed {
+		err = fmt.Errorf("Auction %s is closed, cannot submit puzzles", idHex)
+		return
+	}
+
+	db.puzzles[idHex] = append(db.puzzles[idHex], &memPuzzle{encodedPuzzle: encodedPuzzle, hashedOrder: hashedOrder[:]})
+	return
+}
+
+// SelectPuzzlesForBatch atomically selects every not-yet-selected puzzle
+// for auction id and marks it selected, returning the hashedOrder
+// commitments picked up by this call.
+func (db *MemDB) SelectPuzzlesForBatch(id [32]byte) (hashedOrders [][]byte, err error) {
+	idHex := hex.EncodeToString(id[:])
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	for _, puzzle := range db.puzzles[idHex] {
+		if puzzle.selected {
+			continue
+		}
+		puzzle.selected = true
+		hashedOrders = append(hashedOrders, puzzle.hashedOrder)
+	}
+
+	return
+}
+
+// RevealOrder checks that order and nonce hash to a selected puzzle
+// commitment under auction id, then inserts the order into pairString's
+// auction order table.
+func (db *MemDB) RevealOrder(id [32]byte, pairString string, order cxdb.AuctionOrder, nonce [4]byte) (err error) {
+	idHex := hex.EncodeToString(id[:])
+
+	sum := sha256.Sum256(append(order.Bytes(), nonce[:]...))
+	hashedOrder := sum[:]
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	meta, ok := db.auctionsMeta[idHex]
+	if !ok || meta.closed {
+		err = fmt.Errorf("Auction %s is closed, cannot reveal orders", idHex)
+		return
+	}
+
+	var matched bool
+	for _, puzzle := range db.puzzles[idHex] {
+		if string(puzzle.hashedOrder) != string(hashedOrder) {
+			continue
+		}
+		if !puzzle.selected {
+			err = fmt.Errorf("Puzzle for auction %s was not selected for this batch", idHex)
+			return
+		}
+		if puzzle.revealed {
+			err = fmt.Errorf("Puzzle for auction %s has already been revealed", idHex)
+			return
+		}
+		puzzle.revealed = true
+		matched = true
+		break
+	}
+	if !matched {
+		err = fmt.Errorf("Revealed order does not match a committed puzzle for auction %s", idHex)
+		return
+	}
+
+	db.revealedOrders[pairString] = append(db.revealedOrders[pairString], memRevealedOrder{auctionID: id, order: order})
+	return
+}
+
+// CloseAuction freezes auction id against further puzzle submissions and
+// order reveals, runs match over its revealed orders, persists the
+// resulting fills to price history, and archives the puzzle table so a new
+// auction can be opened in its place.
+func (db *MemDB) CloseAuction(id [32]byte, match func(id [32]byte) ([]cxdb.Fill, error)) (err error) {
+	idHex := hex.EncodeToString(id[:])
+
+	db.mtx.Lock()
+	meta, ok := db.auctionsMeta[idHex]
+	if !ok {
+		db.mtx.Unlock()
+		err = fmt.Errorf("Auction %s not found", idHex)
+		return
+	}
+	if meta.closed {
+		db.mtx.Unlock()
+		err = fmt.Errorf("Auction %s is already closed", idHex)
+		return
+	}
+	meta.closed = true
+	db.mtx.Unlock()
+
+	var fills []cxdb.Fill
+	if fills, err = match(id); err != nil {
+		err = fmt.Errorf("Match callback failed for auction %s: \n%s", idHex, err)
+		return
+	}
+
+	now := time.Now()
+	for _, fill := range fills {
+		if err = db.RecordTrade(fill.Pair, fill.Price, fill.Volume, fill.Side, now); err != nil {
+			err = fmt.Errorf("Could not record fill for auction %s: \n%s", idHex, err)
+			return
+		}
+	}
+
+	archiveName := fmt.Sprintf("auction_%s_archive", idHex)
+	db.mtx.Lock()
+	db.puzzles[archiveName] = db.puzzles[idHex]
+	db.puzzleTables[archiveName] = true
+	delete(db.puzzles, idHex)
+	delete(db.puzzleTables, idHex)
+	db.mtx.Unlock()
+
+	return
+}
+
+// SweepExpiredAuctions closes, matches, settles, and archives any auction
+// that's still open but was opened more than olderThan ago, the same as
+// cxdbsql.DB.SweepExpiredAuctions.
+func (db *MemDB) SweepExpiredAuctions(olderThan time.Duration, match func(id [32]byte) ([]cxdb.Fill, error)) (err error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	db.mtx.Lock()
+	var expiredIDs [][32]byte
+	for idHex, meta := range db.auctionsMeta {
+		if meta.closed || !meta.openTime.Before(cutoff) {
+			continue
+		}
+		var id [32]byte
+		var idBytes []byte
+		if idBytes, err = hex.DecodeString(idHex); err != nil {
+			db.mtx.Unlock()
+			err = fmt.Errorf("Could not decode auction id %s: \n%s", idHex, err)
+			return
+		}
+		copy(id[:], idBytes)
+		expiredIDs = append(expiredIDs, id)
+	}
+	db.mtx.Unlock()
+
+	for _, id := range expiredIDs {
+		if err = db.CloseAuction(id, match); err != nil {
+			return
+		}
+	}
+
+	return
+}
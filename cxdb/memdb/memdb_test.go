@@ -0,0 +1,259 @@
+package memdb
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+
+	"github.com/mit-dci/opencx/cxdb"
+)
+
+// testCoinList returns real coinparam singletons, not fabricated *Params
+// values -- match.AssetFromCoinParam keys its lookup by pointer identity
+// against the package's fixed singletons, so anything else fails to resolve
+// to an asset.
+func testCoinList() []*coinparam.Params {
+	return []*coinparam.Params{
+		&coinparam.RegressionNetParams,
+		&coinparam.LiteRegNetParams,
+	}
+}
+
+// TestSetupTables exercises the setup path chunk0-1 exists to make
+// testable: open a memdb store and run it through SetupCustodyTables,
+// SetupExchangeTables, and SetupAuctionTables without a live MySQL server.
+func TestSetupTables(t *testing.T) {
+	store, err := cxdb.Open("memdb", "", testCoinList())
+	if err != nil {
+		t.Fatalf("Could not open memdb store: %s", err)
+	}
+
+	if err = store.SetupCustodyTables("balances", "deposit", "pending_deposits"); err != nil {
+		t.Fatalf("Could not set up custody tables: %s", err)
+	}
+
+	if err = store.SetupExchangeTables("orders"); err != nil {
+		t.Fatalf("Could not set up exchange tables: %s", err)
+	}
+
+	var auctionID [32]byte
+	if err = store.SetupAuctionTables(auctionID); err != nil {
+		t.Fatalf("Could not set up auction tables: %s", err)
+	}
+
+	if pairs := store.GetPairs(); len(pairs) == 0 {
+		t.Fatalf("Expected at least one pair from GenerateAssetPairs, got none")
+	}
+}
+
+// TestSetPriceGetPrice checks that the price cache round-trips and that an
+// unknown pair is reported as not found.
+func TestSetPriceGetPrice(t *testing.T) {
+	store, err := cxdb.Open("memdb", "", testCoinList())
+	if err != nil {
+		t.Fatalf("Could not open memdb store: %s", err)
+	}
+
+	pairString := store.GetPairs()[0].String()
+
+	if _, err = store.GetPrice(pairString); err == nil {
+		t.Fatalf("Expected an error getting the price for a pair with no trades yet")
+	}
+
+	store.SetPrice(123.45, pairString)
+
+	price, err := store.GetPrice(pairString)
+	if err != nil {
+		t.Fatalf("Could not get price: %s", err)
+	}
+	if price != 123.45 {
+		t.Fatalf("Expected price 123.45, got %f", price)
+	}
+}
+
+// TestRecordTradeGetPriceHistory checks that RecordTrade bucketed through
+// GetPriceHistory produces the expected OHLCV candle, and that it also
+// refreshes the GetPrice cache.
+func TestRecordTradeGetPriceHistory(t *testing.T) {
+	store, err := cxdb.Open("memdb", "", testCoinList())
+	if err != nil {
+		t.Fatalf("Could not open memdb store: %s", err)
+	}
+
+	pairString := store.GetPairs()[0].String()
+
+	bucketStart := time.Unix(1000*60, 0).UTC()
+	trades := []struct {
+		price  float64
+		volume int64
+		offset time.Duration
+	}{
+		{price: 10, volume: 1, offset: 0},
+		{price: 12, volume: 2, offset: 10 * time.Second},
+		{price: 8, volume: 3, offset: 20 * time.Second},
+	}
+	for _, trade := range trades {
+		if err = store.RecordTrade(pairString, trade.price, trade.volume, "buy", bucketStart.Add(trade.offset)); err != nil {
+			t.Fatalf("Could not record trade: %s", err)
+		}
+	}
+
+	candles, err := store.GetPriceHistory(pairString, bucketStart.Add(-time.Minute), bucketStart.Add(time.Minute), "1m")
+	if err != nil {
+		t.Fatalf("Could not get price history: %s", err)
+	}
+	if len(candles) != 1 {
+		t.Fatalf("Expected 1 candle, got %d", len(candles))
+	}
+
+	c := candles[0]
+	if c.Open != 10 || c.High != 12 || c.Low != 8 || c.Close != 8 || c.Volume != 6 {
+		t.Fatalf("Unexpected candle: %+v", c)
+	}
+
+	if price, err := store.GetPrice(pairString); err != nil || price != 8 {
+		t.Fatalf("Expected GetPrice to reflect the last trade's price 8, got %f, err %v", price, err)
+	}
+}
+
+// TestAuctionLifecycle runs a single order through the full sealed-bid
+// commit/reveal/settle lifecycle against a memdb store: submit a puzzle,
+// select it for the batch, reveal the order it committed to, then close the
+// auction and check the match callback's fill was recorded to price history.
+func TestAuctionLifecycle(t *testing.T) {
+	store, err := cxdb.Open("memdb", "", testCoinList())
+	if err != nil {
+		t.Fatalf("Could not open memdb store: %s", err)
+	}
+
+	var auctionID [32]byte
+	if err = store.SetupAuctionTables(auctionID); err != nil {
+		t.Fatalf("Could not set up auction tables: %s", err)
+	}
+
+	pairString := store.GetPairs()[0].String()
+	order := cxdb.AuctionOrder{Pubkey: []byte("pubkey"), OrderID: "order1", Side: "buy", Price: 10, AmountHave: 100, AmountWant: 10}
+	var nonce [4]byte
+
+	sum := sha256.Sum256(append(order.Bytes(), nonce[:]...))
+	if err = store.SubmitPuzzle(auctionID, []byte("encodedpuzzle"), sum); err != nil {
+		t.Fatalf("Could not submit puzzle: %s", err)
+	}
+
+	hashedOrders, err := store.SelectPuzzlesForBatch(auctionID)
+	if err != nil {
+		t.Fatalf("Could not select puzzles for batch: %s", err)
+	}
+	if len(hashedOrders) != 1 {
+		t.Fatalf("Expected 1 selected puzzle, got %d", len(hashedOrders))
+	}
+
+	if err = store.RevealOrder(auctionID, pairString, order, nonce); err != nil {
+		t.Fatalf("Could not reveal order: %s", err)
+	}
+
+	var matchCalled bool
+	match := func(id [32]byte) ([]cxdb.Fill, error) {
+		matchCalled = true
+		return []cxdb.Fill{{Pair: pairString, Price: order.Price, Volume: 1, Side: order.Side}}, nil
+	}
+	if err = store.CloseAuction(auctionID, match); err != nil {
+		t.Fatalf("Could not close auction: %s", err)
+	}
+	if !matchCalled {
+		t.Fatalf("Expected CloseAuction to invoke the match callback")
+	}
+
+	if price, err := store.GetPrice(pairString); err != nil || price != order.Price {
+		t.Fatalf("Expected CloseAuction's fill to be recorded, got price %f, err %v", price, err)
+	}
+
+	if err = store.RevealOrder(auctionID, pairString, order, nonce); err == nil {
+		t.Fatalf("Expected an error revealing an order into a closed auction")
+	}
+}
+
+// TestSweepExpiredAuctionsMatches checks that SweepExpiredAuctions runs the
+// match callback for an auction opened in the past rather than just closing
+// it unmatched, per chunk0-4's settlement fix.
+func TestSweepExpiredAuctionsMatches(t *testing.T) {
+	store, err := cxdb.Open("memdb", "", testCoinList())
+	if err != nil {
+		t.Fatalf("Could not open memdb store: %s", err)
+	}
+
+	var auctionID [32]byte
+	if err = store.SetupAuctionTables(auctionID); err != nil {
+		t.Fatalf("Could not set up auction tables: %s", err)
+	}
+
+	var matchCalled bool
+	match := func(id [32]byte) ([]cxdb.Fill, error) {
+		matchCalled = true
+		return nil, nil
+	}
+	if err = store.SweepExpiredAuctions(0, match); err != nil {
+		t.Fatalf("Could not sweep expired auctions: %s", err)
+	}
+	if !matchCalled {
+		t.Fatalf("Expected SweepExpiredAuctions to invoke the match callback for an expired auction")
+	}
+}
+
+// TestRevealOrderRejectsReplay checks that revealing the same (order, nonce)
+// pair twice is rejected the second time, so one committed puzzle can't be
+// settled as two orders.
+func TestRevealOrderRejectsReplay(t *testing.T) {
+	store, err := cxdb.Open("memdb", "", testCoinList())
+	if err != nil {
+		t.Fatalf("Could not open memdb store: %s", err)
+	}
+
+	var auctionID [32]byte
+	if err = store.SetupAuctionTables(auctionID); err != nil {
+		t.Fatalf("Could not set up auction tables: %s", err)
+	}
+
+	pairString := store.GetPairs()[0].String()
+	order := cxdb.AuctionOrder{Pubkey: []byte("pubkey"), OrderID: "order1", Side: "buy", Price: 10, AmountHave: 100, AmountWant: 10}
+	var nonce [4]byte
+
+	sum := sha256.Sum256(append(order.Bytes(), nonce[:]...))
+	if err = store.SubmitPuzzle(auctionID, []byte("encodedpuzzle"), sum); err != nil {
+		t.Fatalf("Could not submit puzzle: %s", err)
+	}
+	if _, err = store.SelectPuzzlesForBatch(auctionID); err != nil {
+		t.Fatalf("Could not select puzzles for batch: %s", err)
+	}
+
+	if err = store.RevealOrder(auctionID, pairString, order, nonce); err != nil {
+		t.Fatalf("Could not reveal order: %s", err)
+	}
+	if err = store.RevealOrder(auctionID, pairString, order, nonce); err == nil {
+		t.Fatalf("Expected an error replaying an already-revealed order")
+	}
+}
+
+// TestCloseAuctionRejectsDoubleClose checks that closing an already-closed
+// auction is rejected rather than re-running match and re-recording fills.
+func TestCloseAuctionRejectsDoubleClose(t *testing.T) {
+	store, err := cxdb.Open("memdb", "", testCoinList())
+	if err != nil {
+		t.Fatalf("Could not open memdb store: %s", err)
+	}
+
+	var auctionID [32]byte
+	if err = store.SetupAuctionTables(auctionID); err != nil {
+		t.Fatalf("Could not set up auction tables: %s", err)
+	}
+
+	match := func(id [32]byte) ([]cxdb.Fill, error) { return nil, nil }
+	if err = store.CloseAuction(auctionID, match); err != nil {
+		t.Fatalf("Could not close auction: %s", err)
+	}
+	if err = store.CloseAuction(auctionID, match); err == nil {
+		t.Fatalf("Expected an error closing an already-closed auction")
+	}
+}
@@ -0,0 +1,43 @@
+package cxdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// Candle is a single OHLCV candle for a pair over one resolution bucket.
+type Candle struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume int64
+}
+
+// AuctionOrder is a single revealed order within a sealed-bid auction,
+// mirroring the columns a Store's auction order tables are expected to have.
+type AuctionOrder struct {
+	Pubkey     []byte
+	OrderID    string
+	Side       string
+	Price      float64
+	AmountHave uint64
+	AmountWant uint64
+}
+
+// Bytes returns the canonical encoding of o that RevealOrder hashes
+// alongside the nonce to check against a puzzle's committed hashedOrder.
+// OrderID and Side are length-prefixed so that, unlike a plain "|"-joined
+// string, two different field splits can never render to the same bytes.
+func (o AuctionOrder) Bytes() []byte {
+	return []byte(fmt.Sprintf("%x|%d:%s|%d:%s|%f|%d|%d", o.Pubkey, len(o.OrderID), o.OrderID, len(o.Side), o.Side, o.Price, o.AmountHave, o.AmountWant))
+}
+
+// Fill is a single matched trade produced by a CloseAuction match callback.
+type Fill struct {
+	Pair   string
+	Price  float64
+	Volume int64
+	Side   string
+}